@@ -0,0 +1,44 @@
+package golog
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var backtraceAt atomic.Value // holds map[string]struct{}, keyed by "file.go:line"
+
+func init() {
+	if spec := os.Getenv("GOLOG_BACKTRACE_AT"); spec != "" {
+		SetBacktraceAt(spec)
+	}
+}
+
+// SetBacktraceAt configures golog to dump a stack trace whenever a log line
+// is emitted from one of the given file:line locations, even if PRINT_STACK
+// is not set. spec is a comma-separated list of entries like
+// "golog.go:123,server.go:45", matched against the base name of the source
+// file (without its directory).
+func SetBacktraceAt(spec string) {
+	locations := make(map[string]struct{})
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		locations[entry] = struct{}{}
+	}
+	backtraceAt.Store(locations)
+}
+
+// backtraceAtMatches reports whether the given call site was named in the
+// current SetBacktraceAt spec.
+func backtraceAtMatches(file string, line int) bool {
+	locations, _ := backtraceAt.Load().(map[string]struct{})
+	if len(locations) == 0 {
+		return false
+	}
+	_, found := locations[file+":"+strconv.Itoa(line)]
+	return found
+}
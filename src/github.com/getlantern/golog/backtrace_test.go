@@ -0,0 +1,47 @@
+package golog
+
+import (
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// captureSink records every Record it's given, for tests that want to
+// inspect what a Logger call actually produced.
+type captureSink struct {
+	records *[]Record
+}
+
+func (c captureSink) Write(r Record) {
+	*c.records = append(*c.records, r)
+}
+
+func TestBacktraceAtMatchesOnlyConfiguredSite(t *testing.T) {
+	var records []Record
+	handle := RegisterSink(captureSink{records: &records})
+	defer UnregisterSink(handle)
+	defer SetBacktraceAt("")
+
+	l := LoggerFor("golog-backtrace-test")
+
+	_, file, line, _ := runtime.Caller(0)
+	matchedLine := line + 4
+	SetBacktraceAt(filepath.Base(file) + ":" + strconv.Itoa(matchedLine))
+
+	l.Debug("logged from the configured site") // must stay on matchedLine (line+4 above)
+	l.Debug("logged from an unconfigured site")
+
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Line != matchedLine {
+		t.Fatalf("test line drifted: expected first Debug call on line %d, got %d", matchedLine, records[0].Line)
+	}
+	if len(records[0].Stack) == 0 {
+		t.Errorf("expected a stack trace for the call site named in SetBacktraceAt")
+	}
+	if len(records[1].Stack) != 0 {
+		t.Errorf("expected no stack trace for a call site not named in SetBacktraceAt")
+	}
+}
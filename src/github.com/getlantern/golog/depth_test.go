@@ -0,0 +1,39 @@
+package golog
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// wrapErrorDepth is a thin, one-line wrapper of the kind the compiler readily
+// inlines under default build flags. TestErrorDepthSurvivesInlining relies on
+// that: if ErrorDepth's frame attribution were still counting physical stack
+// frames rather than logical ones, inlining this wrapper away would collapse
+// a frame and push the attribution past wrapErrorDepth's caller to the
+// caller's own caller.
+func wrapErrorDepth(l Logger, arg interface{}) error {
+	return l.ErrorDepth(1, arg)
+}
+
+func TestErrorDepthSurvivesInlining(t *testing.T) {
+	var records []Record
+	handle := RegisterSink(captureSink{records: &records})
+	defer UnregisterSink(handle)
+
+	l := LoggerFor("golog-depth-test")
+
+	_, file, line, _ := runtime.Caller(0)
+	wantLine := line + 2
+	if err := wrapErrorDepth(l, "boom"); err == nil { // must stay on wantLine (line+2 above)
+		t.Fatal("expected ErrorDepth to return a non-nil error")
+	}
+
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	if records[0].File != filepath.Base(file) || records[0].Line != wantLine {
+		t.Fatalf("expected attribution to wrapErrorDepth's caller at %s:%d, got %s:%d",
+			filepath.Base(file), wantLine, records[0].File, records[0].Line)
+	}
+}
@@ -0,0 +1,111 @@
+package golog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSinkCascadesToLowerSeverityFiles(t *testing.T) {
+	dir := t.TempDir()
+	fs := &FileSink{dir: dir, files: make(map[string]*rotatingFile)}
+
+	fs.Write(Record{Severity: SeverityError, File: "golog.go", Line: 1, Message: "disk on fire"})
+	fs.Flush()
+
+	for bucket, shouldContain := range map[string]bool{
+		"ERROR":   true,
+		"WARNING": true,
+		"INFO":    true,
+		"FATAL":   false,
+	} {
+		names, err := filepath.Glob(filepath.Join(dir, "app."+bucket+".*"))
+		if err != nil {
+			t.Fatalf("glob app.%s: %v", bucket, err)
+		}
+		if shouldContain && len(names) == 0 {
+			t.Errorf("expected an ERROR record to be cascaded into app.%s, but no such file exists", bucket)
+			continue
+		}
+		if !shouldContain {
+			if len(names) != 0 {
+				t.Errorf("expected no app.%s file for an ERROR record, found %v", bucket, names)
+			}
+			continue
+		}
+		b, err := os.ReadFile(names[0])
+		if err != nil {
+			t.Fatalf("reading %s: %v", names[0], err)
+		}
+		if !strings.Contains(string(b), "disk on fire") {
+			t.Errorf("expected %s to contain the logged message, got %q", names[0], string(b))
+		}
+	}
+}
+
+func TestRotatingFileWriteDoesNotPanicWhenFileCannotBeOpened(t *testing.T) {
+	rf := newRotatingFile(filepath.Join(t.TempDir(), "no-such-subdir", "deeper"), "INFO", FileSinkOptions{})
+
+	rf.write([]byte("should be dropped, not panic\n"))
+	rf.flush()
+}
+
+func TestRotatingFileRecoversAfterATransientRotationFailure(t *testing.T) {
+	dir := t.TempDir()
+	rf := newRotatingFile(dir, "INFO", FileSinkOptions{MaxSize: 1})
+
+	rf.write([]byte("first\n"))
+	rf.flush()
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("removing dir: %v", err)
+	}
+	rf.write([]byte("second\n")) // forces a rotation whose OpenFile fails
+
+	if rf.f != nil || rf.w != nil {
+		t.Fatalf("expected a failed rotation to leave rf.f and rf.w nil, got f=%v w=%v", rf.f, rf.w)
+	}
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("restoring dir: %v", err)
+	}
+	rf.write([]byte("third\n"))
+	rf.flush()
+
+	names, err := filepath.Glob(filepath.Join(dir, "app.INFO.*"))
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("expected exactly one file once the directory came back, got %v", names)
+	}
+	b, err := os.ReadFile(names[0])
+	if err != nil {
+		t.Fatalf("reading %s: %v", names[0], err)
+	}
+	if !strings.Contains(string(b), "third") {
+		t.Errorf("expected the recovered file to contain the post-recovery write, got %q", string(b))
+	}
+}
+
+func TestFileSinkSymlinkPointsAtCurrentFile(t *testing.T) {
+	dir := t.TempDir()
+	fs := &FileSink{dir: dir, opts: FileSinkOptions{Symlink: true}, files: make(map[string]*rotatingFile)}
+
+	fs.Write(Record{Severity: SeverityInfo, File: "golog.go", Line: 1, Message: "hello"})
+	fs.Flush()
+
+	link := filepath.Join(dir, "app.INFO")
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("expected app.INFO to be a symlink: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, target))
+	if err != nil {
+		t.Fatalf("reading symlink target %s: %v", target, err)
+	}
+	if !strings.Contains(string(b), "hello") {
+		t.Errorf("expected symlink target to contain the logged message, got %q", string(b))
+	}
+}
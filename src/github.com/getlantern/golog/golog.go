@@ -2,7 +2,14 @@
 // debug messages to stdout. Trace logging is also supported.
 // Trace logs go to stdout as well, but they are only written if the program
 // is run with environment variable "TRACE=true".
-// A stack dump will be printed after the message if "PRINT_STACK=true".
+// A stack dump will be printed after the message if "PRINT_STACK=true", or
+// selectively for specific file:line locations via SetBacktraceAt /
+// "GOLOG_BACKTRACE_AT".
+//
+// golog also supports glog-style verbosity leveled logging via Logger.V,
+// controlled globally with SetVerbosity/GOLOG_V and per-file with
+// SetVModule/GOLOG_VMODULE, and can additionally write rotated per-severity
+// log files via SetFileSink.
 package golog
 
 import (
@@ -15,11 +22,11 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/getlantern/context"
 	"github.com/getlantern/hidden"
@@ -27,27 +34,38 @@ import (
 )
 
 var (
-	outs                 atomic.Value
-	transparentReporters []ErrorReporter
-	explicitReporters    []ErrorReporter
-	reportersMutex       sync.RWMutex
+	outs              atomic.Value
+	explicitReporters []ErrorReporter
+	reportersMutex    sync.RWMutex
 
 	bufferPool = bpool.NewBufferPool(200)
 )
 
 func init() {
 	ResetOutputs()
+	if v := os.Getenv("GOLOG_V"); v != "" {
+		if level, err := strconv.ParseInt(v, 10, 32); err == nil {
+			SetVerbosity(int32(level))
+		}
+	}
+	if vmodule := os.Getenv("GOLOG_VMODULE"); vmodule != "" {
+		SetVModule(vmodule)
+	}
 }
 
 func SetOutputs(errorOut io.Writer, debugOut io.Writer) {
 	outs.Store(&outputs{
 		ErrorOut: errorOut,
 		DebugOut: debugOut,
+		WarnOut:  errorOut,
 	})
 }
 
 func ResetOutputs() {
 	SetOutputs(os.Stderr, os.Stdout)
+	registerTextSinkOnce.Do(func() {
+		RegisterSink(TextSink{})
+	})
 }
 
 func GetOutputs() *outputs {
@@ -56,20 +74,24 @@ func GetOutputs() *outputs {
 
 // RegisterReporter registers the given ErrorReporter. If explicit is true, the
 // reporter only receives errors logged with ReportedError, otherwise the
-// reporter receives all errors.
+// reporter receives all errors. Transparent (non-explicit) reporters are
+// implemented internally as a LogSink; explicit ones are invoked directly
+// from ReportedError since that's an opt-in that a generic Record doesn't
+// carry.
 func RegisterReporter(reporter ErrorReporter, explicit bool) {
-	reportersMutex.Lock()
-	if explicit {
-		explicitReporters = append(explicitReporters, reporter)
-	} else {
-		transparentReporters = append(transparentReporters, reporter)
+	if !explicit {
+		RegisterSink(reporterSink{reporter: reporter})
+		return
 	}
+	reportersMutex.Lock()
+	explicitReporters = append(explicitReporters, reporter)
 	reportersMutex.Unlock()
 }
 
 type outputs struct {
 	ErrorOut io.Writer
 	DebugOut io.Writer
+	WarnOut  io.Writer
 }
 
 // MultiLine is an interface for arguments that support multi-line output.
@@ -94,6 +116,23 @@ type Logger interface {
 	Debug(arg interface{})
 	// Debugf logs to stdout
 	Debugf(message string, args ...interface{})
+	// DebugDepth logs to stdout, attributing the log line to the caller
+	// depth frames above the caller of DebugDepth rather than to the
+	// immediate caller. This is intended for wrapper libraries that want
+	// the file:line of their own caller to show up in the log.
+	DebugDepth(depth int, arg interface{})
+	// DebugDepthf is the formatted counterpart of DebugDepth
+	DebugDepthf(depth int, message string, args ...interface{})
+
+	// Warn logs to WarnOut (stderr by default)
+	Warn(arg interface{})
+	// Warnf logs to WarnOut (stderr by default)
+	Warnf(message string, args ...interface{})
+	// WarnDepth is like Warn but attributes the log line to the caller
+	// depth frames above the caller of WarnDepth
+	WarnDepth(depth int, arg interface{})
+	// WarnDepthf is the formatted counterpart of WarnDepth
+	WarnDepthf(depth int, message string, args ...interface{})
 
 	// Error logs to stderr
 	Error(arg interface{}) error
@@ -102,16 +141,31 @@ type Logger interface {
 	// Errorf logs to stderr. It returns the first argument that's an error, or
 	// a new error built using fmt.Errorf if none of the arguments are errors.
 	Errorf(message string, args ...interface{}) error
+	// ErrorDepth is like Error but attributes the log line to the caller
+	// depth frames above the caller of ErrorDepth
+	ErrorDepth(depth int, arg interface{}) error
+	// ErrorDepthf is the formatted counterpart of ErrorDepth
+	ErrorDepthf(depth int, message string, args ...interface{}) error
 
 	// Fatal logs to stderr and then exits with status 1
 	Fatal(arg interface{})
 	// Fatalf logs to stderr and then exits with status 1
 	Fatalf(message string, args ...interface{})
+	// FatalDepth is like Fatal but attributes the log line to the caller
+	// depth frames above the caller of FatalDepth
+	FatalDepth(depth int, arg interface{})
+	// FatalDepthf is the formatted counterpart of FatalDepth
+	FatalDepthf(depth int, message string, args ...interface{})
 
 	// Trace logs to stderr only if TRACE=true
 	Trace(arg interface{})
 	// Tracef logs to stderr only if TRACE=true
 	Tracef(message string, args ...interface{})
+	// TraceDepth is like Trace but attributes the log line to the caller
+	// depth frames above the caller of TraceDepth
+	TraceDepth(depth int, arg interface{})
+	// TraceDepthf is the formatted counterpart of TraceDepth
+	TraceDepthf(depth int, message string, args ...interface{})
 
 	// TraceOut provides access to an io.Writer to which trace information can
 	// be streamed. If running with environment variable "TRACE=true", TraceOut
@@ -126,13 +180,29 @@ type Logger interface {
 
 	// AsStdLogger returns an standard logger
 	AsStdLogger() *log.Logger
+
+	// V returns a Verbose value that is true if logging at the given
+	// verbosity level is enabled for the calling file: if a pattern
+	// registered via SetVModule matches the calling file, that pattern's
+	// level must be at least level, and the global level is not consulted;
+	// otherwise the global level set via SetVerbosity must be at least
+	// level. Logging through the
+	// returned Verbose is a no-op otherwise, e.g.:
+	//
+	//   log.V(2).Infof("connected to %v", addr)
+	V(level int32) Verbose
 }
 
+// maxCallers bounds how many physical stack frames linePrefix captures per
+// call. It needs enough headroom for golog's own internal frames (print,
+// printf, the Depth variants' callers, ...) plus a useful amount of the
+// caller's stack to report in a backtrace.
+const maxCallers = 64
+
 func LoggerFor(prefix string) Logger {
 
 	l := &logger{
 		prefix: prefix + ": ",
-		pc:     make([]uintptr, 10),
 	}
 
 	trace := os.Getenv("TRACE")
@@ -164,92 +234,144 @@ type logger struct {
 	traceOut   io.Writer
 	printStack bool
 	outs       atomic.Value
-	pc         []uintptr
-	funcForPc  *runtime.Func
 }
 
-// attaches the file and line number corresponding to
-// the log message
-func (l *logger) linePrefix(skipFrames int) string {
-	runtime.Callers(skipFrames, l.pc)
-	funcForPc := runtime.FuncForPC(l.pc[0])
-	file, line := funcForPc.FileLine(l.pc[0] - 1)
-	return fmt.Sprintf("%s%s:%d ", l.prefix, filepath.Base(file), line)
-}
-
-func (l *logger) print(out io.Writer, buf *bytes.Buffer, skipFrames int, severity string, arg interface{}) {
-	if buf == nil {
-		buf = bufferPool.Get()
-		defer bufferPool.Put(buf)
+// linePrefix attaches the file and line number corresponding to the log
+// message, returning the formatted prefix along with the bare file name and
+// line so that callers can check it against SetBacktraceAt without
+// reparsing it, plus the rest of the caller's stack for a later stack dump.
+// Everything it captures is local to this call: a Logger is routinely
+// shared across goroutines (see V's doc comment), so per-call state like the
+// captured pcs and frames must never be stashed on l itself.
+//
+// skipFrames counts logical call frames starting at linePrefix itself (1 is
+// linePrefix, 2 its caller, and so on), walked via runtime.CallersFrames
+// rather than treated as a count of physical stack frames: a thin wrapper
+// the compiler inlines collapses physical frames without removing the
+// logical call it represents, so a skip count calibrated against physical
+// frames (as plain runtime.Callers(skip, ...) would require) silently
+// drifts once inlining is in play.
+func (l *logger) linePrefix(skipFrames int) (prefix string, file string, line int, stack []runtime.Frame) {
+	var pc [maxCallers]uintptr
+	n := runtime.Callers(1, pc[:])
+	cf := runtime.CallersFrames(pc[:n])
+
+	var frame runtime.Frame
+	more := true
+	for i := 0; i < skipFrames && more; i++ {
+		frame, more = cf.Next()
 	}
 
-	linePrefix := l.linePrefix(skipFrames)
-	writeHeader := func() {
-		buf.WriteString(severity)
-		buf.WriteString(" ")
-		buf.WriteString(linePrefix)
+	stack = append(stack, frame)
+	for more {
+		var next runtime.Frame
+		next, more = cf.Next()
+		if next.Function == "" || strings.HasPrefix(next.Function, "runtime.") {
+			break
+		}
+		stack = append(stack, next)
 	}
+
+	file = filepath.Base(frame.File)
+	line = frame.Line
+	return fmt.Sprintf("%s%s:%d ", l.prefix, file, line), file, line, stack
+}
+
+// print builds a Record for arg and dispatches it to every registered
+// LogSink. err, if non-nil, is the original error being logged (only set
+// for ERROR-severity calls), so that sinks like ErrorReporter adapters can
+// inspect it directly.
+func (l *logger) print(skipFrames int, severity Severity, err error, arg interface{}) Record {
+	_, file, line, stack := l.linePrefix(skipFrames)
+
+	var message string
 	if arg != nil {
-		ml, isMultiline := arg.(MultiLine)
-		if !isMultiline {
-			writeHeader()
-			fmt.Fprintf(buf, "%v", arg)
-			printContext(buf, arg)
-			buf.WriteByte('\n')
-		} else {
+		if ml, isMultiline := arg.(MultiLine); isMultiline {
 			mlp := ml.MultiLinePrinter()
-			first := true
+			buf := bufferPool.Get()
+			defer bufferPool.Put(buf)
 			for {
-				writeHeader()
 				more := mlp(buf)
-				if first {
-					printContext(buf, arg)
-					first = false
+				if more {
+					buf.WriteByte('\n')
 				}
-				buf.WriteByte('\n')
 				if !more {
 					break
 				}
 			}
+			message = buf.String()
+		} else {
+			message = fmt.Sprintf("%v", arg)
 		}
 	}
-	b := []byte(hidden.Clean(buf.String()))
-	_, err := out.Write(b)
-	if err != nil {
-		errorOnLogging(err)
-	}
-	if l.printStack {
-		l.doPrintStack()
-	}
-}
 
-func (l *logger) printf(out io.Writer, buf *bytes.Buffer, skipFrames int, severity string, err error, message string, args ...interface{}) {
-	if buf == nil {
-		buf = bufferPool.Get()
-		defer bufferPool.Put(buf)
+	r := Record{
+		Time:     time.Now(),
+		Severity: severity,
+		Prefix:   l.prefix,
+		File:     file,
+		Line:     line,
+		Message:  message,
+		Context:  context.AsMap(arg, false),
+		Err:      err,
 	}
-	buf.WriteString(severity)
-	buf.WriteString(" ")
-	buf.WriteString(l.linePrefix(skipFrames))
-	fmt.Fprintf(buf, message, args...)
-	printContext(buf, err)
-	buf.WriteByte('\n')
-	b := []byte(hidden.Clean(buf.String()))
-	_, err2 := out.Write(b)
-	if err2 != nil {
-		errorOnLogging(err)
+	if l.printStack || backtraceAtMatches(file, line) {
+		r.Stack = stack
 	}
-	if l.printStack {
-		l.doPrintStack()
+	dispatch(r)
+	return r
+}
+
+func (l *logger) printf(skipFrames int, severity Severity, err error, message string, args ...interface{}) Record {
+	_, file, line, stack := l.linePrefix(skipFrames)
+
+	r := Record{
+		Time:     time.Now(),
+		Severity: severity,
+		Prefix:   l.prefix,
+		File:     file,
+		Line:     line,
+		Message:  fmt.Sprintf(message, args...),
+		Context:  context.AsMap(err, false),
+		Err:      err,
 	}
+	if l.printStack || backtraceAtMatches(file, line) {
+		r.Stack = stack
+	}
+	dispatch(r)
+	return r
 }
 
 func (l *logger) Debug(arg interface{}) {
-	l.print(GetOutputs().DebugOut, nil, 4, "DEBUG", arg)
+	l.print(4, SeverityDebug, nil, arg)
 }
 
 func (l *logger) Debugf(message string, args ...interface{}) {
-	l.printf(GetOutputs().DebugOut, nil, 4, "DEBUG", nil, message, args...)
+	l.printf(4, SeverityDebug, nil, message, args...)
+}
+
+func (l *logger) DebugDepth(depth int, arg interface{}) {
+	l.print(4+depth, SeverityDebug, nil, arg)
+}
+
+func (l *logger) DebugDepthf(depth int, message string, args ...interface{}) {
+	l.printf(4+depth, SeverityDebug, nil, message, args...)
+}
+
+func (l *logger) Warn(arg interface{}) {
+	l.print(4, SeverityWarn, nil, arg)
+}
+
+func (l *logger) Warnf(message string, args ...interface{}) {
+	l.printf(4, SeverityWarn, nil, message, args...)
+}
+
+func (l *logger) WarnDepth(depth int, arg interface{}) {
+	l.print(4+depth, SeverityWarn, nil, arg)
+}
+
+func (l *logger) WarnDepthf(depth int, message string, args ...interface{}) {
+	l.printf(4+depth, SeverityWarn, nil, message, args...)
 }
 
 func (l *logger) Error(arg interface{}) error {
@@ -260,6 +382,10 @@ func (l *logger) ReportedError(err error) error {
 	return l.errorSkipFrames(true, err, 1)
 }
 
+func (l *logger) ErrorDepth(depth int, arg interface{}) error {
+	return l.errorSkipFrames(false, arg, 1+depth)
+}
+
 func (l *logger) errorSkipFrames(reportRequested bool, arg interface{}, skipFrames int) error {
 	var err error
 	switch e := arg.(type) {
@@ -268,13 +394,19 @@ func (l *logger) errorSkipFrames(reportRequested bool, arg interface{}, skipFram
 	default:
 		err = fmt.Errorf("%v", e)
 	}
-	buf := bufferPool.Get()
-	defer bufferPool.Put(buf)
-	l.print(GetOutputs().ErrorOut, buf, skipFrames+4, "ERROR", err)
-	return report(reportRequested, err, buf.String())
+	r := l.print(skipFrames+4, SeverityError, err, err)
+	return report(reportRequested, err, formatTextLine(r))
 }
 
 func (l *logger) Errorf(message string, args ...interface{}) error {
+	return l.errorfSkipFrames(1, message, args...)
+}
+
+func (l *logger) ErrorDepthf(depth int, message string, args ...interface{}) error {
+	return l.errorfSkipFrames(1+depth, message, args...)
+}
+
+func (l *logger) errorfSkipFrames(skipFrames int, message string, args ...interface{}) error {
 	var err error
 	var hasError bool
 	for _, arg := range args {
@@ -285,36 +417,58 @@ func (l *logger) Errorf(message string, args ...interface{}) error {
 			break
 		}
 	}
-	buf := bufferPool.Get()
-	defer bufferPool.Put(buf)
 	if !hasError {
 		err = fmt.Errorf(message, args...)
-		l.print(GetOutputs().ErrorOut, buf, 4, "ERROR", err)
-	} else {
-		l.printf(GetOutputs().ErrorOut, buf, 4, "ERROR", err, message, args...)
 	}
-	return report(false, err, buf.String())
+	r := l.printf(skipFrames+4, SeverityError, err, message, args...)
+	return report(false, err, formatTextLine(r))
 }
 
 func (l *logger) Fatal(arg interface{}) {
-	l.print(GetOutputs().ErrorOut, nil, 4, "FATAL", arg)
+	l.print(4, SeverityFatal, nil, arg)
+	Flush()
 	os.Exit(1)
 }
 
 func (l *logger) Fatalf(message string, args ...interface{}) {
-	l.printf(GetOutputs().ErrorOut, nil, 4, "FATAL", nil, message, args...)
+	l.printf(4, SeverityFatal, nil, message, args...)
+	Flush()
+	os.Exit(1)
+}
+
+func (l *logger) FatalDepth(depth int, arg interface{}) {
+	l.print(4+depth, SeverityFatal, nil, arg)
+	Flush()
+	os.Exit(1)
+}
+
+func (l *logger) FatalDepthf(depth int, message string, args ...interface{}) {
+	l.printf(4+depth, SeverityFatal, nil, message, args...)
+	Flush()
 	os.Exit(1)
 }
 
 func (l *logger) Trace(arg interface{}) {
 	if l.traceOn {
-		l.print(GetOutputs().DebugOut, nil, 4, "TRACE", arg)
+		l.print(4, SeverityTrace, nil, arg)
 	}
 }
 
 func (l *logger) Tracef(message string, args ...interface{}) {
 	if l.traceOn {
-		l.printf(GetOutputs().DebugOut, nil, 4, "TRACE", nil, message, args...)
+		l.printf(4, SeverityTrace, nil, message, args...)
+	}
+}
+
+func (l *logger) TraceDepth(depth int, arg interface{}) {
+	if l.traceOn {
+		l.print(4+depth, SeverityTrace, nil, arg)
+	}
+}
+
+func (l *logger) TraceDepthf(depth int, message string, args ...interface{}) {
+	if l.traceOn {
+		l.printf(4+depth, SeverityTrace, nil, message, args...)
 	}
 }
 
@@ -326,6 +480,17 @@ func (l *logger) IsTraceEnabled() bool {
 	return l.traceOn
 }
 
+// V reports whether verbosity level is currently enabled for the calling
+// file. Since a single Logger is routinely shared by every file in a
+// package, the resolved threshold is cached per call site (see
+// effectiveV), not on l itself: otherwise the first file to call V() would
+// pin its vmodule level for every other file sharing the same Logger.
+func (l *logger) V(level int32) Verbose {
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:])
+	return Verbose{enabled: level <= effectiveV(pcs[0]), logger: l}
+}
+
 func (l *logger) newTraceWriter() io.Writer {
 	pr, pw := io.Pipe()
 	br := bufio.NewReader(pr)
@@ -349,9 +514,9 @@ func (l *logger) newTraceWriter() io.Writer {
 			line, err := br.ReadString('\n')
 			if err == nil {
 				// Log the line (minus the trailing newline)
-				l.print(GetOutputs().DebugOut, nil, 6, "TRACE", line[:len(line)-1])
+				l.print(6, SeverityTrace, nil, line[:len(line)-1])
 			} else {
-				l.printf(GetOutputs().DebugOut, nil, 6, "TRACE", nil, "TraceWriter closed due to unexpected error: %v", err)
+				l.printf(6, SeverityTrace, nil, "TraceWriter closed due to unexpected error: %v", err)
 				return
 			}
 		}
@@ -364,14 +529,16 @@ type errorWriter struct {
 	l *logger
 }
 
-// Write implements method of io.Writer, due to different call depth,
-// it will not log correct file and line prefix
+// Write implements io.Writer, logging via ErrorDepth so that the file:line
+// attributed to the message is that of the code that called into the
+// wrapped *log.Logger (e.g. via Println/Printf/Fatal), skipping the two
+// frames the stdlib log package interposes between that call and here.
 func (w *errorWriter) Write(p []byte) (n int, err error) {
 	s := string(p)
 	if s[len(s)-1] == '\n' {
 		s = s[:len(s)-1]
 	}
-	w.l.print(GetOutputs().ErrorOut, nil, 6, "ERROR", s)
+	w.l.ErrorDepth(3, s)
 	return len(p), nil
 }
 
@@ -379,68 +546,23 @@ func (l *logger) AsStdLogger() *log.Logger {
 	return log.New(&errorWriter{l}, "", 0)
 }
 
-func (l *logger) doPrintStack() {
-	var b []byte
-	buf := bytes.NewBuffer(b)
-	for _, pc := range l.pc {
-		funcForPc := runtime.FuncForPC(pc)
-		if funcForPc == nil {
-			break
-		}
-		name := funcForPc.Name()
-		if strings.HasPrefix(name, "runtime.") {
-			break
-		}
-		file, line := funcForPc.FileLine(pc)
-		fmt.Fprintf(buf, "\t%s\t%s: %d\n", name, file, line)
-	}
-	if _, err := buf.WriteTo(os.Stderr); err != nil {
-		errorOnLogging(err)
-	}
-}
-
 func errorOnLogging(err error) {
 	fmt.Fprintf(os.Stderr, "Unable to log: %v\n", err)
 }
 
-func printContext(buf *bytes.Buffer, err interface{}) {
-	// Note - we don't include globals when printing in order to avoid polluting the text log
-	values := context.AsMap(err, false)
-	if len(values) == 0 {
-		return
-	}
-	buf.WriteString(" [")
-	var keys []string
-	for key := range values {
-		keys = append(keys, key)
-	}
-	sort.Strings(keys)
-	for i, key := range keys {
-		value := values[key]
-		if i > 0 {
-			buf.WriteString(" ")
-		}
-		buf.WriteString(key)
-		buf.WriteString("=")
-		fmt.Fprintf(buf, "%v", value)
-	}
-	buf.WriteByte(']')
-}
-
+// report invokes the explicit reporters (those that only want errors logged
+// via ReportedError) when reportRequested is set. Transparent reporters are
+// registered as a LogSink in RegisterReporter and so have already been
+// invoked as part of dispatch when print/printf built the Record.
 func report(reportRequested bool, err error, text string) error {
+	if !reportRequested {
+		return err
+	}
 	reportersMutex.RLock()
-	doReport := func(reporter ErrorReporter) {
+	defer reportersMutex.RUnlock()
+	for _, reporter := range explicitReporters {
 		// We include globals when reporting
 		reporter(err, hidden.Clean(text), context.AsMap(err, true))
 	}
-	for _, reporter := range transparentReporters {
-		doReport(reporter)
-	}
-	if reportRequested {
-		for _, reporter := range explicitReporters {
-			doReport(reporter)
-		}
-	}
-	reportersMutex.RUnlock()
 	return err
 }
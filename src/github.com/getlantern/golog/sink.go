@@ -0,0 +1,299 @@
+package golog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getlantern/context"
+	"github.com/getlantern/hidden"
+)
+
+// Severity identifies how serious a log Record is.
+type Severity int
+
+const (
+	SeverityDebug Severity = iota
+	SeverityInfo
+	SeverityWarn
+	SeverityError
+	SeverityFatal
+	SeverityTrace
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityDebug:
+		return "DEBUG"
+	case SeverityInfo:
+		return "INFO"
+	case SeverityWarn:
+		return "WARN"
+	case SeverityError:
+		return "ERROR"
+	case SeverityFatal:
+		return "FATAL"
+	case SeverityTrace:
+		return "TRACE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Record is a single structured log event. logger.print and logger.printf
+// build one of these for every logging call and hand it to every registered
+// LogSink, rather than writing text directly to an io.Writer.
+type Record struct {
+	Time     time.Time
+	Severity Severity
+	// Prefix is this Logger's prefix, e.g. "mypackage: ".
+	Prefix string
+	// File is the base name (no directory) of the source file that made
+	// the logging call.
+	File string
+	Line int
+	// Message is the rendered log message. For MultiLine arguments, it
+	// holds all lines joined with "\n".
+	Message string
+	// Context holds the key/value pairs attached to the logged error or
+	// argument via the context package, excluding global context so as not
+	// to pollute text logs. It is nil if there was none.
+	Context map[string]interface{}
+	// Stack is populated when PRINT_STACK=true or the call site matches
+	// SetBacktraceAt.
+	Stack []runtime.Frame
+	// Err is the original error for ERROR-severity records produced by
+	// Error/Errorf/ReportedError/ErrorDepth(f), so that ErrorReporters and
+	// other sinks can inspect it directly instead of reparsing Message.
+	Err error
+}
+
+// LogSink receives every Record logged by every Logger. Implementations
+// should return quickly since Write runs on the logging call's goroutine;
+// slow sinks should buffer internally and drop records rather than block.
+type LogSink interface {
+	Write(r Record)
+}
+
+// Flusher is implemented by a LogSink that buffers output internally and
+// needs an explicit flush to make it durable, e.g. one wrapping a
+// bufio.Writer. Flush calls it on every registered sink that implements it.
+type Flusher interface {
+	Flush()
+}
+
+var (
+	sinksMutex sync.RWMutex
+	sinks      []registeredSink
+	nextHandle uint64
+
+	registerTextSinkOnce sync.Once
+)
+
+// SinkHandle identifies a sink registered via RegisterSink, for later removal
+// with UnregisterSink. LogSink implementations aren't necessarily comparable
+// (e.g. a struct with a slice or func field), so a handle rather than the
+// sink itself is what identifies a registration.
+type SinkHandle uint64
+
+type registeredSink struct {
+	handle SinkHandle
+	sink   LogSink
+}
+
+// RegisterSink adds sink to the set of sinks that receive every logged
+// Record, returning a handle that can be passed to UnregisterSink to remove
+// it again.
+func RegisterSink(sink LogSink) SinkHandle {
+	sinksMutex.Lock()
+	defer sinksMutex.Unlock()
+	nextHandle++
+	handle := SinkHandle(nextHandle)
+	sinks = append(sinks, registeredSink{handle: handle, sink: sink})
+	return handle
+}
+
+// UnregisterSink removes a sink previously added with RegisterSink. It's a
+// no-op if handle isn't currently registered.
+func UnregisterSink(handle SinkHandle) {
+	sinksMutex.Lock()
+	defer sinksMutex.Unlock()
+	for i, rs := range sinks {
+		if rs.handle == handle {
+			sinks = append(sinks[:i:i], sinks[i+1:]...)
+			return
+		}
+	}
+}
+
+func dispatch(r Record) {
+	sinksMutex.RLock()
+	defer sinksMutex.RUnlock()
+	for _, rs := range sinks {
+		rs.sink.Write(r)
+	}
+}
+
+// Flush flushes every registered sink that implements Flusher, such as the
+// file sink configured via SetFileSink or a user-registered sink that wraps
+// a buffered io.Writer. Fatal(f) calls this before os.Exit, and programs
+// that register buffered sinks should also defer golog.Flush() in main so
+// buffered lines aren't lost on a clean exit.
+func Flush() {
+	sinksMutex.RLock()
+	flushers := make([]Flusher, 0, len(sinks))
+	for _, rs := range sinks {
+		if f, ok := rs.sink.(Flusher); ok {
+			flushers = append(flushers, f)
+		}
+	}
+	sinksMutex.RUnlock()
+	for _, f := range flushers {
+		f.Flush()
+	}
+}
+
+// outputForSeverity picks the configured outputs writer that TextSink uses
+// for a given severity.
+func outputForSeverity(s Severity) io.Writer {
+	o := GetOutputs()
+	switch s {
+	case SeverityError, SeverityFatal:
+		return o.ErrorOut
+	case SeverityWarn:
+		return o.WarnOut
+	default:
+		return o.DebugOut
+	}
+}
+
+// writeTextLines renders r the way golog has always rendered plain text log
+// lines: "SEVERITY prefix:file:line message [key=value ...]", one per line
+// of a multi-line message, with context only appended to the first line,
+// followed by an optional stack dump.
+func writeTextLines(buf *bytes.Buffer, r Record) {
+	lines := strings.Split(r.Message, "\n")
+	for i, line := range lines {
+		buf.WriteString(r.Severity.String())
+		buf.WriteString(" ")
+		buf.WriteString(r.Prefix)
+		buf.WriteString(r.File)
+		buf.WriteString(":")
+		buf.WriteString(strconv.Itoa(r.Line))
+		buf.WriteString(" ")
+		buf.WriteString(line)
+		if i == 0 {
+			writeContextMap(buf, r.Context)
+		}
+		buf.WriteByte('\n')
+	}
+	for _, frame := range r.Stack {
+		buf.WriteString("\t")
+		buf.WriteString(frame.Function)
+		buf.WriteString("\t")
+		buf.WriteString(frame.File)
+		buf.WriteString(": ")
+		buf.WriteString(strconv.Itoa(frame.Line))
+		buf.WriteByte('\n')
+	}
+}
+
+func formatTextLine(r Record) string {
+	buf := bufferPool.Get()
+	defer bufferPool.Put(buf)
+	writeTextLines(buf, r)
+	return buf.String()
+}
+
+func writeContextMap(buf *bytes.Buffer, values map[string]interface{}) {
+	if len(values) == 0 {
+		return
+	}
+	buf.WriteString(" [")
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
+		buf.WriteString(key)
+		buf.WriteString("=")
+		fmt.Fprintf(buf, "%v", values[key])
+	}
+	buf.WriteByte(']')
+}
+
+// TextSink reproduces golog's original stderr/stdout text logging. It's
+// auto-registered by ResetOutputs so existing programs keep behaving
+// exactly as before without having to register anything themselves.
+type TextSink struct{}
+
+func (TextSink) Write(r Record) {
+	buf := bufferPool.Get()
+	defer bufferPool.Put(buf)
+	writeTextLines(buf, r)
+	b := []byte(hidden.Clean(buf.String()))
+	if _, err := outputForSeverity(r.Severity).Write(b); err != nil {
+		errorOnLogging(err)
+	}
+}
+
+// JSONSink writes one JSON object per Record to Out, suitable for ingestion
+// by things like Elasticsearch, Loki or Stackdriver.
+type JSONSink struct {
+	Out io.Writer
+}
+
+type jsonRecord struct {
+	Time     time.Time              `json:"time"`
+	Severity string                 `json:"severity"`
+	Prefix   string                 `json:"prefix,omitempty"`
+	File     string                 `json:"file"`
+	Line     int                    `json:"line"`
+	Message  string                 `json:"message"`
+	Context  map[string]interface{} `json:"context,omitempty"`
+}
+
+func (s JSONSink) Write(r Record) {
+	b, err := json.Marshal(jsonRecord{
+		Time:     r.Time,
+		Severity: r.Severity.String(),
+		Prefix:   strings.TrimSuffix(r.Prefix, ": "),
+		File:     r.File,
+		Line:     r.Line,
+		Message:  hidden.Clean(r.Message),
+		Context:  r.Context,
+	})
+	if err != nil {
+		errorOnLogging(err)
+		return
+	}
+	b = append(b, '\n')
+	if _, err := s.Out.Write(b); err != nil {
+		errorOnLogging(err)
+	}
+}
+
+// reporterSink adapts a transparent ErrorReporter (one that should see
+// every error, as opposed to only those logged via ReportedError) to the
+// LogSink interface.
+type reporterSink struct {
+	reporter ErrorReporter
+}
+
+func (rs reporterSink) Write(r Record) {
+	if r.Severity != SeverityError || r.Err == nil {
+		return
+	}
+	rs.reporter(r.Err, hidden.Clean(formatTextLine(r)), context.AsMap(r.Err, true))
+}
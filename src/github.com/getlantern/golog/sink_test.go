@@ -0,0 +1,79 @@
+package golog
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTextSinkFormatsSeverityPrefixAndContext(t *testing.T) {
+	buf := &bytes.Buffer{}
+	SetOutputs(buf, buf)
+	defer ResetOutputs()
+
+	TextSink{}.Write(Record{
+		Severity: SeverityError,
+		Prefix:   "mypkg: ",
+		File:     "golog.go",
+		Line:     42,
+		Message:  "boom",
+		Context:  map[string]interface{}{"key": "value"},
+	})
+
+	got := buf.String()
+	want := "ERROR mypkg: golog.go:42 boom [key=value]\n"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestTextSinkAppendsStackDump(t *testing.T) {
+	buf := &bytes.Buffer{}
+	SetOutputs(buf, buf)
+	defer ResetOutputs()
+
+	TextSink{}.Write(Record{
+		Severity: SeverityDebug,
+		File:     "golog.go",
+		Line:     1,
+		Message:  "with stack",
+		Stack:    []runtime.Frame{{Function: "pkg.Func", File: "golog.go", Line: 2}},
+	})
+
+	if !strings.Contains(buf.String(), "pkg.Func\tgolog.go: 2\n") {
+		t.Fatalf("expected stack dump in output, got %q", buf.String())
+	}
+}
+
+func TestJSONSinkWritesOneObjectPerLine(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := JSONSink{Out: buf}
+
+	sink.Write(Record{
+		Severity: SeverityWarn,
+		Prefix:   "mypkg: ",
+		File:     "golog.go",
+		Line:     7,
+		Message:  "careful",
+		Context:  map[string]interface{}{"attempt": 3},
+	})
+	sink.Write(Record{Severity: SeverityInfo, File: "golog.go", Line: 8, Message: "second"})
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one JSON object per Write, got %d lines: %q", len(lines), buf.String())
+	}
+
+	var rec jsonRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("first line isn't valid JSON: %v", err)
+	}
+	if rec.Severity != "WARN" || rec.Prefix != "mypkg" || rec.File != "golog.go" || rec.Line != 7 || rec.Message != "careful" {
+		t.Fatalf("unexpected decoded record: %+v", rec)
+	}
+	if rec.Context["attempt"].(float64) != 3 {
+		t.Fatalf("expected context to round-trip, got %+v", rec.Context)
+	}
+}
@@ -0,0 +1,179 @@
+package golog
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	verbosity   int32 // current global verbosity level, set via SetVerbosity
+	vCacheEpoch int64 // bumped every time SetVModule or SetVerbosity changes what V resolves to
+
+	vmodulePatterns atomic.Value // holds []vmodulePattern
+	vCache          sync.Map     // map[uintptr]vCacheEntry, keyed by call site PC
+)
+
+// vCacheEntry is a call site's cached resolution of its effective V
+// threshold, valid as long as its epoch still matches vCacheEpoch.
+type vCacheEntry struct {
+	epoch int64
+	level int32
+}
+
+// vmodulePattern is a single "pattern=level" entry parsed out of a vmodule
+// spec.
+type vmodulePattern struct {
+	pattern string
+	isGlob  bool
+	level   int32
+}
+
+func (p vmodulePattern) matches(file string) bool {
+	if !p.isGlob {
+		base := filepath.Base(file)
+		return p.pattern == strings.TrimSuffix(base, ".go")
+	}
+	if ok, _ := filepath.Match(p.pattern, file); ok {
+		return true
+	}
+	ok, _ := filepath.Match(p.pattern, filepath.Base(file))
+	return ok
+}
+
+// Verbose is returned by Logger.V and determines whether logging at a given
+// verbosity level is currently enabled. Its methods are no-ops when the
+// level is disabled, so callers can write log.V(2).Infof(...) unconditionally
+// without guarding it with an if statement.
+type Verbose struct {
+	enabled bool
+	logger  *logger
+}
+
+func (v Verbose) Info(arg interface{}) {
+	if v.enabled {
+		v.logger.print(4, SeverityInfo, nil, arg)
+	}
+}
+
+func (v Verbose) Infof(message string, args ...interface{}) {
+	if v.enabled {
+		v.logger.printf(4, SeverityInfo, nil, message, args...)
+	}
+}
+
+func (v Verbose) Debug(arg interface{}) {
+	if v.enabled {
+		v.logger.print(4, SeverityDebug, nil, arg)
+	}
+}
+
+func (v Verbose) Tracef(message string, args ...interface{}) {
+	if v.enabled {
+		v.logger.printf(4, SeverityTrace, nil, message, args...)
+	}
+}
+
+// SetVerbosity sets the global verbosity level used by Logger.V. Call sites
+// in files matched by a pattern registered via SetVModule are governed by
+// that pattern's level instead.
+func SetVerbosity(level int32) {
+	atomic.StoreInt32(&verbosity, level)
+	// Cached call sites resolved to the old global level; invalidate them.
+	atomic.AddInt64(&vCacheEpoch, 1)
+}
+
+// SetVModule sets the per-file verbosity overrides from a comma-separated
+// list of pattern=level entries, e.g. "gofilter=2,http*=1". pattern may
+// be a bare source file name without its ".go" suffix, or a glob (supporting
+// * and ?) matched against the full file path or its base name.
+func SetVModule(spec string) {
+	var patterns []vmodulePattern
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		level, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 32)
+		if err != nil {
+			continue
+		}
+		pattern := strings.TrimSpace(parts[0])
+		patterns = append(patterns, vmodulePattern{
+			pattern: pattern,
+			isGlob:  strings.ContainsAny(pattern, "*?/"),
+			level:   int32(level),
+		})
+	}
+	vmodulePatterns.Store(patterns)
+	atomic.AddInt64(&vCacheEpoch, 1)
+}
+
+// effectiveV resolves the verbosity threshold that applies to the call site
+// identified by pc, consulting the vmodule cache first so that disabled call
+// sites cost little more than an atomic load and a map lookup.
+func effectiveV(pc uintptr) int32 {
+	epoch := atomic.LoadInt64(&vCacheEpoch)
+	if cached, ok := vCache.Load(pc); ok {
+		entry := cached.(vCacheEntry)
+		if entry.epoch == epoch {
+			return entry.level
+		}
+	}
+	level := resolveV(pc)
+	vCache.Store(pc, vCacheEntry{epoch: epoch, level: level})
+	return level
+}
+
+// resolveV expects pc to be a return address, as produced by
+// runtime.Callers (e.g. the call site captured by logger.V), rather than a
+// function entry point. A return address can point past the end of its
+// call's line, onto whatever follows it in the binary — potentially a
+// different function entirely once the compiler has inlined the call — so
+// both the function lookup and the line lookup resolve pc-1 instead, the
+// same way linePrefix does.
+func resolveV(pc uintptr) int32 {
+	base := atomic.LoadInt32(&verbosity)
+	fn := runtime.FuncForPC(pc - 1)
+	if fn == nil {
+		return base
+	}
+	file, _ := fn.FileLine(pc - 1)
+	patterns, _ := vmodulePatterns.Load().([]vmodulePattern)
+	for _, p := range patterns {
+		if p.matches(file) {
+			return p.level
+		}
+	}
+	return base
+}
+
+// ServeVerbosity is an http.HandlerFunc that exposes the global verbosity
+// level for inspection and adjustment at runtime, so it can be raised or
+// lowered without restarting the process. A GET request returns the current
+// level; a POST or PUT with a "level" form value sets it.
+func ServeVerbosity(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		fmt.Fprintf(w, "%d", atomic.LoadInt32(&verbosity))
+	case http.MethodPost, http.MethodPut:
+		level, err := strconv.ParseInt(r.FormValue("level"), 10, 32)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		SetVerbosity(int32(level))
+		fmt.Fprintf(w, "%d", level)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,32 @@
+package golog
+
+import "testing"
+
+// TestVModuleOverridesPerFile runs under default build flags (in
+// particular, without -gcflags=-l) so it catches the case where a normal
+// build inlines V()'s caller: resolveV must resolve the return address
+// Callers hands it (pc-1), not the PC itself, or every call site resolves
+// to whatever code happens to follow it and no vmodule pattern ever
+// matches.
+func TestVModuleOverridesPerFile(t *testing.T) {
+	var records []Record
+	handle := RegisterSink(captureSink{records: &records})
+	defer UnregisterSink(handle)
+	defer SetVerbosity(0)
+	defer SetVModule("")
+
+	SetVerbosity(0)
+	SetVModule("vmodule_site_a_test=2,vmodule_site_b_test=0")
+
+	l := LoggerFor("vmodule-test")
+
+	logAtSiteA(l, 1) // site a's override is 2, so V(1) is enabled
+	logAtSiteB(l, 1) // site b's override is 0, so V(1) is disabled
+
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record logged from the site with a matching override, got %d", len(records))
+	}
+	if records[0].Message != "site a" {
+		t.Errorf("expected the record to come from site a, got message %q", records[0].Message)
+	}
+}
@@ -0,0 +1,8 @@
+package golog
+
+// logAtSiteB exists purely so TestVModuleOverridesPerFile has a call site in
+// a file whose name it can target with SetVModule, distinct from
+// vmodule_site_a_test.go's.
+func logAtSiteB(l Logger, level int32) {
+	l.V(level).Info("site b")
+}